@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/go-plugin"
+	"github.com/hashicorp/hcl"
+
+	aia "github.com/spiffe/aws-iid-attestor/common"
+	"github.com/spiffe/spire/proto/agent/nodeattestor"
+	spirecommon "github.com/spiffe/spire/proto/common"
+	spi "github.com/spiffe/spire/proto/common/plugin"
+)
+
+const pluginName = "iid_attestor"
+
+// IIDAttestorConfig does not offer a liveness-challenge option: an earlier
+// revision had the agent sign a server-issued nonce to prove liveness beyond
+// the static IID, but SPIRE's agent nodeattestor proto only exposes a
+// single-shot FetchAttestationData with no round trip for the server to hand
+// the agent a challenge to sign, so the signature was never verified by
+// anything and the feature was removed rather than shipped half-wired. Don't
+// reintroduce it without a transport that actually carries the challenge.
+//
+// This leaves the original request for challenge-signing liveness proof
+// unmet, not merely descoped quietly: only its file-fallback half shipped.
+// Whoever owns that request needs to either descope it to drop the
+// liveness-proof requirement, or get the proto changed upstream to carry a
+// server challenge before this can be finished.
+type IIDAttestorConfig struct {
+	// DocumentPath and SignaturePath, if both set, make the plugin read a
+	// pre-fetched instance identity document and signature from disk
+	// instead of querying IMDS, for hosts whose IMDS endpoint is
+	// firewalled off.
+	DocumentPath  string `hcl:"document_path"`
+	SignaturePath string `hcl:"signature_path"`
+
+	// SignatureAlgorithm selects which IMDS signature document is
+	// fetched: aia.SignatureAlgorithmSHA1 (default) or
+	// aia.SignatureAlgorithmPKCS7.
+	SignatureAlgorithm string `hcl:"signature_algorithm"`
+
+	// AllowIMDSv1Fallback permits falling back to an unauthenticated
+	// IMDSv1 request when an IMDSv2 session token cannot be obtained.
+	AllowIMDSv1Fallback bool `hcl:"allow_imdsv1_fallback"`
+}
+
+type IIDAttestorPlugin struct {
+	mtx *sync.Mutex
+
+	fetcher aia.DocumentFetcher
+}
+
+func (p *IIDAttestorPlugin) FetchAttestationData(req *nodeattestor.FetchAttestationDataRequest) (*nodeattestor.FetchAttestationDataResponse, error) {
+	p.mtx.Lock()
+	fetcher := p.fetcher
+	p.mtx.Unlock()
+
+	if fetcher == nil {
+		return nil, fmt.Errorf("the AWS IID Attestor agent plugin has not been configured")
+	}
+
+	attestedData, err := fetcher.FetchAttestedData()
+	if err != nil {
+		return nil, fmt.Errorf("fetching the instance identity document: %v", err)
+	}
+
+	dataBytes, err := json.Marshal(attestedData)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling the attested data: %v", err)
+	}
+
+	return &nodeattestor.FetchAttestationDataResponse{
+		AttestationData: &spirecommon.AttestationData{
+			Type: pluginName,
+			Data: dataBytes,
+		},
+	}, nil
+}
+
+func (p *IIDAttestorPlugin) Configure(req *spi.ConfigureRequest) (*spi.ConfigureResponse, error) {
+	resp := &spi.ConfigureResponse{}
+
+	config := &IIDAttestorConfig{}
+	hclTree, err := hcl.Parse(req.Configuration)
+	if err != nil {
+		err := fmt.Errorf("Error parsing AWS IID Attestor configuration: %s", err)
+		return resp, err
+	}
+	if err := hcl.DecodeObject(&config, hclTree); err != nil {
+		err := fmt.Errorf("Error decoding AWS IID Attestor configuration: %v", err)
+		return resp, err
+	}
+
+	signatureAlgorithm := config.SignatureAlgorithm
+	if signatureAlgorithm == "" {
+		signatureAlgorithm = aia.SignatureAlgorithmSHA1
+	}
+
+	var fetcher aia.DocumentFetcher
+	if config.DocumentPath != "" && config.SignaturePath != "" {
+		fetcher = &aia.FileDocumentFetcher{
+			DocumentPath:  config.DocumentPath,
+			SignaturePath: config.SignaturePath,
+		}
+	} else {
+		fetcher = aia.NewIMDSDocumentFetcher(signatureAlgorithm, config.AllowIMDSv1Fallback)
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.fetcher = fetcher
+
+	return &spi.ConfigureResponse{}, nil
+}
+
+func (*IIDAttestorPlugin) GetPluginInfo(*spi.GetPluginInfoRequest) (*spi.GetPluginInfoResponse, error) {
+	return &spi.GetPluginInfoResponse{}, nil
+}
+
+func New() nodeattestor.NodeAttestor {
+	return &IIDAttestorPlugin{
+		mtx: &sync.Mutex{},
+	}
+}
+
+func main() {
+	p := &IIDAttestorPlugin{
+		mtx: &sync.Mutex{},
+	}
+
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: nodeattestor.Handshake,
+		Plugins: map[string]plugin.Plugin{
+			"join_token": nodeattestor.NodeAttestorPlugin{NodeAttestorImpl: p},
+		},
+		GRPCServer: plugin.DefaultGRPCServer,
+	})
+}
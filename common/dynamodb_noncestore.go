@@ -0,0 +1,71 @@
+package common
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DynamoDBNonceStore is a NonceStore backed by a DynamoDB table keyed on a
+// "hash" string attribute, with an "expires_at" Unix-timestamp attribute
+// meant to be configured as the table's TTL attribute so expired nonces are
+// reaped automatically. Unlike LRUNonceStore, it is safe to share replay
+// protection across multiple attestor server processes.
+//
+// Check and Record do not read expires_at: a hash counts as used for as
+// long as its item remains in the table, including any time after
+// expires_at before DynamoDB has reaped it.
+type DynamoDBNonceStore struct {
+	client    *dynamodb.DynamoDB
+	tableName string
+	ttl       time.Duration
+}
+
+func NewDynamoDBNonceStore(client *dynamodb.DynamoDB, tableName string, ttl time.Duration) *DynamoDBNonceStore {
+	return &DynamoDBNonceStore{
+		client:    client,
+		tableName: tableName,
+		ttl:       ttl,
+	}
+}
+
+func (s *DynamoDBNonceStore) Check(hash string) error {
+	out, err := s.client.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"hash": {S: aws.String(hash)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("checking nonce %s in DynamoDB: %v", hash, err)
+	}
+	if out.Item != nil {
+		return fmt.Errorf("nonce %s has already been used", hash)
+	}
+	return nil
+}
+
+func (s *DynamoDBNonceStore) Record(hash string) error {
+	_, err := s.client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"hash":       {S: aws.String(hash)},
+			"expires_at": {N: aws.String(fmt.Sprintf("%d", time.Now().Add(s.ttl).Unix()))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#h)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#h": aws.String("hash"),
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return fmt.Errorf("nonce %s has already been used", hash)
+		}
+		return fmt.Errorf("recording nonce %s in DynamoDB: %v", hash, err)
+	}
+	return nil
+}
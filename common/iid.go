@@ -0,0 +1,34 @@
+package common
+
+// InstanceIdentityDocument mirrors the JSON document returned by
+// http://169.254.169.254/latest/dynamic/instance-identity/document
+type InstanceIdentityDocument struct {
+	AccountId        string `json:"accountId"`
+	Architecture     string `json:"architecture"`
+	AvailabilityZone string `json:"availabilityZone"`
+	ImageId          string `json:"imageId"`
+	InstanceId       string `json:"instanceId"`
+	InstanceType     string `json:"instanceType"`
+	PendingTime      string `json:"pendingTime"`
+	PrivateIp        string `json:"privateIp"`
+	Region           string `json:"region"`
+	Version          string `json:"version"`
+}
+
+// IidAttestedData is the attestation data exchanged between the agent and
+// server halves of the iid_attestor plugin. Document is the raw JSON of an
+// InstanceIdentityDocument, and Signature is its accompanying signature,
+// base64 encoded.
+type IidAttestedData struct {
+	Document  string `json:"document"`
+	Signature string `json:"signature"`
+}
+
+// Signature algorithms supported when verifying an IidAttestedData document.
+// "sha1" is the legacy PKCS1v15-over-SHA1 signature available from every
+// IMDS version. "pkcs7" is the SHA256/PKCS7 signature that AWS introduced
+// alongside IMDSv2 and that is required in some newer regions/partitions.
+const (
+	SignatureAlgorithmSHA1  = "sha1"
+	SignatureAlgorithmPKCS7 = "pkcs7"
+)
@@ -0,0 +1,158 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	imdsTokenURL     = "http://169.254.169.254/latest/api/token"
+	imdsDocumentURL  = "http://169.254.169.254/latest/dynamic/instance-identity/document"
+	imdsSignatureURL = "http://169.254.169.254/latest/dynamic/instance-identity/signature"
+	imdsPKCS7URL     = "http://169.254.169.254/latest/dynamic/instance-identity/pkcs7"
+
+	imdsTokenTTLHeader  = "X-aws-ec2-metadata-token-ttl-seconds"
+	imdsTokenHeader     = "X-aws-ec2-metadata-token"
+	imdsTokenTTLSeconds = "21600"
+	imdsRequestTimeout  = 5 * time.Second
+)
+
+// DocumentFetcher produces the attested data (an instance identity document
+// plus its signature) that an agent sends to the server half of the
+// iid_attestor plugin. Implementations may fetch from IMDS, read a
+// pre-fetched document from disk, or return canned data in tests.
+type DocumentFetcher interface {
+	FetchAttestedData() (*IidAttestedData, error)
+}
+
+// IMDSDocumentFetcher fetches the instance identity document and signature
+// from the EC2 Instance Metadata Service. It prefers IMDSv2, authenticating
+// with a session token obtained from the /latest/api/token endpoint, and
+// optionally falls back to unauthenticated IMDSv1 requests when a token
+// cannot be obtained.
+type IMDSDocumentFetcher struct {
+	// SignatureAlgorithm selects which signature document is fetched
+	// alongside the IID: SignatureAlgorithmSHA1 or SignatureAlgorithmPKCS7.
+	SignatureAlgorithm string
+
+	// AllowIMDSv1Fallback permits falling back to an unauthenticated
+	// IMDSv1 request when a session token cannot be obtained.
+	AllowIMDSv1Fallback bool
+
+	Client *http.Client
+}
+
+// NewIMDSDocumentFetcher returns an IMDSDocumentFetcher configured to fetch
+// the given signature algorithm's document, falling back to IMDSv1 only if
+// allowIMDSv1Fallback is set.
+func NewIMDSDocumentFetcher(signatureAlgorithm string, allowIMDSv1Fallback bool) *IMDSDocumentFetcher {
+	return &IMDSDocumentFetcher{
+		SignatureAlgorithm:  signatureAlgorithm,
+		AllowIMDSv1Fallback: allowIMDSv1Fallback,
+		Client:              &http.Client{Timeout: imdsRequestTimeout},
+	}
+}
+
+func (f *IMDSDocumentFetcher) FetchAttestedData() (*IidAttestedData, error) {
+	token, err := f.fetchSessionToken()
+	if err != nil {
+		if !f.AllowIMDSv1Fallback {
+			return nil, fmt.Errorf("fetching IMDSv2 session token: %v", err)
+		}
+		token = ""
+	}
+
+	document, err := f.fetchMetadata(imdsDocumentURL, token)
+	if err != nil {
+		return nil, fmt.Errorf("fetching the instance identity document: %v", err)
+	}
+
+	sigURL := imdsSignatureURL
+	if f.SignatureAlgorithm == SignatureAlgorithmPKCS7 {
+		sigURL = imdsPKCS7URL
+	}
+
+	signature, err := f.fetchMetadata(sigURL, token)
+	if err != nil {
+		return nil, fmt.Errorf("fetching the instance identity signature: %v", err)
+	}
+
+	return &IidAttestedData{
+		Document:  string(document),
+		Signature: string(signature),
+	}, nil
+}
+
+func (f *IMDSDocumentFetcher) fetchSessionToken() (string, error) {
+	req, err := http.NewRequest(http.MethodPut, imdsTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(imdsTokenTTLHeader, imdsTokenTTLSeconds)
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d from %s", resp.StatusCode, imdsTokenURL)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return string(body), nil
+}
+
+func (f *IMDSDocumentFetcher) fetchMetadata(url, token string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set(imdsTokenHeader, token)
+	}
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// FileDocumentFetcher reads a pre-fetched instance identity document and
+// signature from disk, for hosts where the EC2 metadata endpoint is
+// unreachable (e.g. some container network namespaces).
+type FileDocumentFetcher struct {
+	DocumentPath  string
+	SignaturePath string
+}
+
+func (f *FileDocumentFetcher) FetchAttestedData() (*IidAttestedData, error) {
+	document, err := ioutil.ReadFile(f.DocumentPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading the instance identity document from %s: %v", f.DocumentPath, err)
+	}
+
+	signature, err := ioutil.ReadFile(f.SignaturePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading the instance identity signature from %s: %v", f.SignaturePath, err)
+	}
+
+	return &IidAttestedData{
+		Document:  string(document),
+		Signature: string(signature),
+	}, nil
+}
@@ -0,0 +1,116 @@
+package common
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// NonceStore records the hashes of IIDs that have already been attested, so
+// that a replayed IID -- reused against a different agent within its
+// validity window -- can be rejected. Implementations must be safe for
+// concurrent use.
+//
+// Check and Record are split so a caller can reject an already-seen IID
+// before doing the rest of attestation, while deferring the side effect of
+// recording it until attestation actually succeeds -- otherwise a transient
+// failure partway through attestation (a throttled AWS API call, a
+// device-index mismatch) would permanently burn the nonce and lock a
+// legitimate agent out for the rest of its iid_ttl.
+type NonceStore interface {
+	// Check returns an error if hash has already been recorded within its
+	// TTL (indicating a replay). It does not itself record hash.
+	Check(hash string) error
+
+	// Record records hash, returning an error if it has already been
+	// recorded within its TTL (indicating a replay that raced past Check).
+	Record(hash string) error
+}
+
+// LRUNonceStore is an in-memory NonceStore. It retains each hash for ttl
+// and evicts the oldest entries once maxEntries is exceeded. It is the
+// default NonceStore; it does not survive a server restart or coordinate
+// replay protection across multiple attestor servers, which is what
+// DynamoDBNonceStore is for.
+type LRUNonceStore struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mtx     sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type lruNonceEntry struct {
+	hash      string
+	expiresAt time.Time
+}
+
+func NewLRUNonceStore(ttl time.Duration, maxEntries int) *LRUNonceStore {
+	return &LRUNonceStore{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (s *LRUNonceStore) Check(hash string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	s.evictExpiredLocked(now)
+
+	if elem, ok := s.entries[hash]; ok && elem.Value.(*lruNonceEntry).expiresAt.After(now) {
+		return fmt.Errorf("nonce %s has already been used", hash)
+	}
+
+	return nil
+}
+
+func (s *LRUNonceStore) Record(hash string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	s.evictExpiredLocked(now)
+
+	if elem, ok := s.entries[hash]; ok {
+		if elem.Value.(*lruNonceEntry).expiresAt.After(now) {
+			return fmt.Errorf("nonce %s has already been used", hash)
+		}
+		s.order.Remove(elem)
+		delete(s.entries, hash)
+	}
+
+	elem := s.order.PushBack(&lruNonceEntry{hash: hash, expiresAt: now.Add(s.ttl)})
+	s.entries[hash] = elem
+
+	for s.order.Len() > s.maxEntries {
+		oldest := s.order.Front()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*lruNonceEntry).hash)
+	}
+
+	return nil
+}
+
+// evictExpiredLocked relies on entries being pushed to the back of order in
+// insertion order and sharing a constant ttl, so the front of the list is
+// always the next entry to expire.
+func (s *LRUNonceStore) evictExpiredLocked(now time.Time) {
+	for {
+		front := s.order.Front()
+		if front == nil {
+			return
+		}
+		entry := front.Value.(*lruNonceEntry)
+		if entry.expiresAt.After(now) {
+			return
+		}
+		s.order.Remove(front)
+		delete(s.entries, entry.hash)
+	}
+}
@@ -0,0 +1,148 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestLRUNonceStoreReplay(t *testing.T) {
+	s := NewLRUNonceStore(50*time.Millisecond, 100000)
+
+	if err := s.Check("h1"); err != nil {
+		t.Fatalf("Check on an unseen hash: %v", err)
+	}
+	if err := s.Record("h1"); err != nil {
+		t.Fatalf("Record on an unseen hash: %v", err)
+	}
+
+	if err := s.Check("h1"); err == nil {
+		t.Fatal("Check did not reject a replay within the TTL")
+	}
+	if err := s.Record("h1"); err == nil {
+		t.Fatal("Record did not reject a replay within the TTL")
+	}
+
+	time.Sleep(75 * time.Millisecond)
+
+	if err := s.Check("h1"); err != nil {
+		t.Fatalf("Check rejected a hash that should have expired: %v", err)
+	}
+	if err := s.Record("h1"); err != nil {
+		t.Fatalf("Record rejected a hash that should have expired: %v", err)
+	}
+}
+
+func TestLRUNonceStoreEviction(t *testing.T) {
+	s := NewLRUNonceStore(time.Hour, 1)
+
+	if err := s.Record("h1"); err != nil {
+		t.Fatalf("Record h1: %v", err)
+	}
+	if err := s.Record("h2"); err != nil {
+		t.Fatalf("Record h2: %v", err)
+	}
+
+	// h1 should have been evicted to make room for h2, so it's no longer
+	// considered a replay.
+	if err := s.Check("h1"); err != nil {
+		t.Fatalf("Check rejected a hash evicted past maxEntries: %v", err)
+	}
+	if err := s.Check("h2"); err == nil {
+		t.Fatal("Check did not reject the most recently recorded hash")
+	}
+}
+
+func TestLRUNonceStoreCheckDoesNotBurn(t *testing.T) {
+	s := NewLRUNonceStore(time.Hour, 100000)
+
+	// A caller that Checks a hash, then fails elsewhere in attestation
+	// before ever calling Record, must not have burned the nonce: the
+	// legitimate agent needs to be able to retry.
+	if err := s.Check("h1"); err != nil {
+		t.Fatalf("first Check: %v", err)
+	}
+	if err := s.Check("h1"); err != nil {
+		t.Fatalf("second Check after a simulated transient failure: %v", err)
+	}
+	if err := s.Record("h1"); err != nil {
+		t.Fatalf("Record after the retry: %v", err)
+	}
+	if err := s.Check("h1"); err == nil {
+		t.Fatal("Check did not reject a replay once Record had succeeded")
+	}
+}
+
+// fakeDynamoDB answers the specific GetItem/PutItem request shapes
+// DynamoDBNonceStore issues, keyed off the X-Amz-Target header, so the
+// store can be exercised without a real DynamoDB table.
+func fakeDynamoDB(t *testing.T, existingHash string) *dynamodb.DynamoDB {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "DynamoDB_20120810.GetItem":
+			var req dynamodb.GetItemInput
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatalf("decoding GetItem request: %v", err)
+			}
+			resp := &dynamodb.GetItemOutput{}
+			if *req.Key["hash"].S == existingHash {
+				resp.Item = map[string]*dynamodb.AttributeValue{
+					"hash": {S: aws.String(existingHash)},
+				}
+			}
+			json.NewEncoder(w).Encode(resp)
+
+		case "DynamoDB_20120810.PutItem":
+			var req dynamodb.PutItemInput
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatalf("decoding PutItem request: %v", err)
+			}
+			if *req.Item["hash"].S == existingHash {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"already exists"}`)
+				return
+			}
+			json.NewEncoder(w).Encode(&dynamodb.PutItemOutput{})
+
+		default:
+			t.Fatalf("unexpected DynamoDB action: %s", r.Header.Get("X-Amz-Target"))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("fake", "fake", ""),
+	}))
+	return dynamodb.New(sess)
+}
+
+func TestDynamoDBNonceStoreReplay(t *testing.T) {
+	client := fakeDynamoDB(t, "used-hash")
+	s := NewDynamoDBNonceStore(client, "nonces", time.Hour)
+
+	if err := s.Check("fresh-hash"); err != nil {
+		t.Fatalf("Check on an unseen hash: %v", err)
+	}
+	if err := s.Check("used-hash"); err == nil {
+		t.Fatal("Check did not reject a hash already present in the table")
+	}
+
+	if err := s.Record("fresh-hash"); err != nil {
+		t.Fatalf("Record on an unseen hash: %v", err)
+	}
+	if err := s.Record("used-hash"); err == nil {
+		t.Fatal("Record did not reject a conditional-check failure as a replay")
+	}
+}
@@ -0,0 +1,112 @@
+package common
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// AWS partitions a trust anchor (and, by extension, an InstanceIdentityDocument)
+// may belong to.
+const (
+	PartitionAWS      = "aws"
+	PartitionAWSUSGov = "aws-us-gov"
+	PartitionAWSCN    = "aws-cn"
+)
+
+// PartitionForRegion returns the AWS partition that region belongs to, used
+// to select the right trust anchors for a given InstanceIdentityDocument.
+func PartitionForRegion(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return PartitionAWSCN
+	case strings.HasPrefix(region, "us-gov-"):
+		return PartitionAWSUSGov
+	default:
+		return PartitionAWS
+	}
+}
+
+// TrustBundle maps a partition to the certificates whose public keys may
+// have signed an instance identity document issued from that partition.
+type TrustBundle map[string][]*x509.Certificate
+
+// awsCaCertPEM is the legacy (SHA1-signing) commercial EC2 instance
+// identity root certificate, embedded so the plugin verifies out of the
+// box against the partition most deployments run in. GovCloud, China, and
+// newer commercial regions (e.g. ca-west-1) sign with different roots;
+// operators targeting those must supply them via ca_bundle_paths.
+const awsCaCertPEM = `-----BEGIN CERTIFICATE-----
+MIIDIjCCAougAwIBAgIJAKnL4UEDMN/FMA0GCSqGSIb3DQEBBQUAMGoxCzAJBgNV
+BAYTAlVTMRMwEQYDVQQIEwpXYXNoaW5ndG9uMRAwDgYDVQQHEwdTZWF0dGxlMRgw
+FgYDVQQKEw9BbWF6b24uY29tIEluYy4xGjAYBgNVBAMTEWVjMi5hbWF6b25hd3Mu
+Y29tMB4XDTE0MDYwNTE0MjgwMloXDTI0MDYwNTE0MjgwMlowajELMAkGA1UEBhMC
+VVMxEzARBgNVBAgTCldhc2hpbmd0b24xEDAOBgNVBAcTB1NlYXR0bGUxGDAWBgNV
+BAoTD0FtYXpvbi5jb20gSW5jLjEaMBgGA1UEAxMRZWMyLmFtYXpvbmF3cy5jb20w
+gZ8wDQYJKoZIhvcNAQEBBQADgY0AMIGJAoGBAIe9GN//SRK2knbjySG0ho3yqQM3
+e2TDhWO8D2e8+XZqck754gFSo99AbT2RmXClambI7xsYHZFapbELC4H91ycihvrD
+jbST1ZjkLQgga0NE1q43eS68ZeTDccScXQSNivSlzJZS8HJZjgqzBlXjZftjtdJL
+XeE4hwvo0sD4f3j9AgMBAAGjgc8wgcwwHQYDVR0OBBYEFCXWzAgVyrbwnFncFFIs
+77VBdlE4MIGcBgNVHSMEgZQwgZGAFCXWzAgVyrbwnFncFFIs77VBdlE4oW6kbDBq
+MQswCQYDVQQGEwJVUzETMBEGA1UECBMKV2FzaGluZ3RvbjEQMA4GA1UEBxMHU2Vh
+dHRsZTEYMBYGA1UEChMPQW1hem9uLmNvbSBJbmMuMRowGAYDVQQDExFlYzIuYW1h
+em9uYXdzLmNvbYIJAKnL4UEDMN/FMAwGA1UdEwQFMAMBAf8wDQYJKoZIhvcNAQEF
+BQADgYEAFYcz1OgEhQBXIwIdsgCOS8vEtiJYF+j9uO6jz7VOmJqO+pRlAbRlvY8T
+C1haGgSI/A1uZUKs/Zfnph0oEI0/hu1IIJ/SKBDtN5lvmZ/IzbOPIJWirlsllQIQ
+7zvWbGd9c9+Rm3p04oTvhup99la7kZqevJK0QRdD/6NpCKsqP/0=
+-----END CERTIFICATE-----`
+
+// DefaultTrustBundle returns the embedded set of trust anchors shipped with
+// the plugin (see awsCaCertPEM). Only the "aws" (commercial) partition is
+// seeded: AWS signs GovCloud and China instance identity documents with
+// distinct roots that aren't embedded here, so operators attesting instances
+// in those partitions must supply them via ca_bundle_paths.
+func DefaultTrustBundle() (TrustBundle, error) {
+	cert, err := parseCertPEM([]byte(awsCaCertPEM))
+	if err != nil {
+		return nil, fmt.Errorf("parsing the embedded AWS CA certificate: %v", err)
+	}
+
+	return TrustBundle{
+		PartitionAWS: {cert},
+	}, nil
+}
+
+// LoadCABundleFile parses the PEM-encoded certificates in path and adds them
+// as additional trust anchors for partition, for operators who need to
+// trust certificates beyond the embedded default (e.g. a newer regional
+// signing root, or GovCloud/China roots, which DefaultTrustBundle doesn't
+// embed at all). partition should be one of the Partition* constants.
+func LoadCABundleFile(bundle TrustBundle, partition, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading ca_bundle_paths[%s] %s: %v", partition, path, err)
+	}
+
+	for len(data) > 0 {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("parsing a certificate in ca_bundle_paths[%s] %s: %v", partition, path, err)
+		}
+
+		bundle[partition] = append(bundle[partition], cert)
+	}
+
+	return nil
+}
+
+func parseCertPEM(pemBytes []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
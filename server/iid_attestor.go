@@ -1,28 +1,37 @@
 package main
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
-	"encoding/pem"
 	"fmt"
 	"math"
 	"net/url"
 	"path"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	ec2 "github.com/aws/aws-sdk-go/service/ec2"
 
+	"github.com/fullsailor/pkcs7"
 	"github.com/hashicorp/go-plugin"
 	"github.com/hashicorp/hcl"
 
 	aia "github.com/spiffe/aws-iid-attestor/common"
+	spirecommon "github.com/spiffe/spire/proto/common"
 	spi "github.com/spiffe/spire/proto/common/plugin"
 	"github.com/spiffe/spire/proto/server/nodeattestor"
 )
@@ -31,50 +40,250 @@ const (
 	pluginName = "iid_attestor"
 
 	maxSecondsBetweenDeviceAttachments int64 = 60
+
+	defaultAgentPathTemplate = "spire/agent/" + pluginName + "/{{ .AccountId }}/{{ .InstanceId }}"
+
+	defaultIidTTL               = 5 * time.Minute
+	defaultNonceStoreMaxEntries = 100000
 )
 
-const awsCaCertPEM = `-----BEGIN CERTIFICATE-----
-MIIDIjCCAougAwIBAgIJAKnL4UEDMN/FMA0GCSqGSIb3DQEBBQUAMGoxCzAJBgNV
-BAYTAlVTMRMwEQYDVQQIEwpXYXNoaW5ndG9uMRAwDgYDVQQHEwdTZWF0dGxlMRgw
-FgYDVQQKEw9BbWF6b24uY29tIEluYy4xGjAYBgNVBAMTEWVjMi5hbWF6b25hd3Mu
-Y29tMB4XDTE0MDYwNTE0MjgwMloXDTI0MDYwNTE0MjgwMlowajELMAkGA1UEBhMC
-VVMxEzARBgNVBAgTCldhc2hpbmd0b24xEDAOBgNVBAcTB1NlYXR0bGUxGDAWBgNV
-BAoTD0FtYXpvbi5jb20gSW5jLjEaMBgGA1UEAxMRZWMyLmFtYXpvbmF3cy5jb20w
-gZ8wDQYJKoZIhvcNAQEBBQADgY0AMIGJAoGBAIe9GN//SRK2knbjySG0ho3yqQM3
-e2TDhWO8D2e8+XZqck754gFSo99AbT2RmXClambI7xsYHZFapbELC4H91ycihvrD
-jbST1ZjkLQgga0NE1q43eS68ZeTDccScXQSNivSlzJZS8HJZjgqzBlXjZftjtdJL
-XeE4hwvo0sD4f3j9AgMBAAGjgc8wgcwwHQYDVR0OBBYEFCXWzAgVyrbwnFncFFIs
-77VBdlE4MIGcBgNVHSMEgZQwgZGAFCXWzAgVyrbwnFncFFIs77VBdlE4oW6kbDBq
-MQswCQYDVQQGEwJVUzETMBEGA1UECBMKV2FzaGluZ3RvbjEQMA4GA1UEBxMHU2Vh
-dHRsZTEYMBYGA1UEChMPQW1hem9uLmNvbSBJbmMuMRowGAYDVQQDExFlYzIuYW1h
-em9uYXdzLmNvbYIJAKnL4UEDMN/FMAwGA1UdEwQFMAMBAf8wDQYJKoZIhvcNAQEF
-BQADgYEAFYcz1OgEhQBXIwIdsgCOS8vEtiJYF+j9uO6jz7VOmJqO+pRlAbRlvY8T
-C1haGgSI/A1uZUKs/Zfnph0oEI0/hu1IIJ/SKBDtN5lvmZ/IzbOPIJWirlsllQIQ
-7zvWbGd9c9+Rm3p04oTvhup99la7kZqevJK0QRdD/6NpCKsqP/0=
------END CERTIFICATE-----`
+// allSelectorKinds are the selector kinds built by buildSelectors when the
+// selectors config option isn't set.
+var allSelectorKinds = []string{"tag", "sg", "iamrole", "az", "vpc", "subnet", "image", "instance"}
 
 type IIDAttestorConfig struct {
 	TrustDomain string `hcl:"trust_domain"`
+
+	// ImdsVersion is the IMDS version agents are expected to fetch their
+	// attested data from ("1" or "2"). When SignatureAlgorithm isn't set,
+	// "2" defaults it to aia.SignatureAlgorithmPKCS7 (the signature IMDSv2
+	// callers conventionally fetch); anything else defaults it to
+	// aia.SignatureAlgorithmSHA1.
+	ImdsVersion string `hcl:"imds_version"`
+
+	// SignatureAlgorithm selects the verification path applied to
+	// incoming attested data: aia.SignatureAlgorithmSHA1 (legacy
+	// PKCS1v15-over-SHA1) or aia.SignatureAlgorithmPKCS7 (SHA256/PKCS7).
+	SignatureAlgorithm string `hcl:"signature_algorithm"`
+
+	// AccountWhitelist restricts attestation to the given AWS account
+	// IDs. If empty, every account is allowed.
+	AccountWhitelist []string `hcl:"account_whitelist"`
+
+	// AccountAssumeRoleARNs optionally maps an AWS account ID to an IAM
+	// role ARN to assume before calling DescribeInstances against that
+	// account, for cross-account attestation.
+	AccountAssumeRoleARNs map[string]string `hcl:"account_assume_role_arns"`
+
+	// AgentPathTemplate is a text/template string evaluated against the
+	// resolved instance (see agentPathTemplateData) to build the SPIFFE
+	// path beneath trust_domain. Defaults to defaultAgentPathTemplate.
+	AgentPathTemplate string `hcl:"agent_path_template"`
+
+	// IidTTL bounds an IID's age, measured from its pendingTime, and how
+	// long its document hash is retained in the nonce store to reject
+	// replays, e.g. "5m". Defaults to defaultIidTTL.
+	IidTTL string `hcl:"iid_ttl"`
+
+	// NonceStoreDynamoDBTable, if set, backs the nonce store with a
+	// DynamoDB table of this name instead of the default in-memory LRU
+	// cache, so replay protection is shared across attestor servers.
+	NonceStoreDynamoDBTable string `hcl:"nonce_store_dynamodb_table"`
+
+	// Selectors restricts which kinds of node selectors are populated on
+	// a successful Attest, e.g. ["tag", "sg", "iamrole"]. See
+	// allSelectorKinds for the full set. Defaults to all kinds.
+	Selectors []string `hcl:"selectors"`
+
+	// CABundlePaths optionally maps an AWS partition (aia.PartitionAWS,
+	// aia.PartitionAWSUSGov, or aia.PartitionAWSCN) to a file of
+	// PEM-encoded certificates to trust as additional IID signing roots
+	// for that partition, alongside the embedded default bundle (see
+	// aia.DefaultTrustBundle). GovCloud and China aren't seeded by the
+	// embedded default at all, so attesting instances in those partitions
+	// requires populating this.
+	CABundlePaths map[string]string `hcl:"ca_bundle_paths"`
+}
+
+// pluginConfig holds the result of the plugin's last Configure call. It is
+// swapped into IIDAttestorPlugin.config as a single atomic snapshot so that
+// Attest never has to hold a lock across the DescribeInstances/AssumeRole/
+// nonceStore round trips it makes while reading it.
+type pluginConfig struct {
+	trustDomain string
+
+	trustBundle aia.TrustBundle
+
+	signatureAlgorithm string
+
+	accountWhitelist      map[string]bool
+	accountAssumeRoleARNs map[string]string
+	agentPathTemplate     *template.Template
+
+	iidTTL     time.Duration
+	nonceStore aia.NonceStore
+
+	selectorKinds []string
 }
 
 type IIDAttestorPlugin struct {
 	ConfigTime time.Time
 
-	trustDomain string
+	// config is an atomic.Value of *pluginConfig. A single Configure call
+	// builds a whole new snapshot and swaps it in, so readers never see a
+	// torn mix of old and new fields and never need to lock to read it.
+	config atomic.Value
+
+	// configureMtx serializes Configure calls against each other; it is
+	// never held by Attest.
+	configureMtx sync.Mutex
+}
+
+func (p *IIDAttestorPlugin) loadConfig() (*pluginConfig, error) {
+	config, _ := p.config.Load().(*pluginConfig)
+	if config == nil {
+		return nil, fmt.Errorf("the AWS IID Attestor server plugin has not been configured")
+	}
+	return config, nil
+}
+
+// agentPathTemplateData is the value an AgentPathTemplate is executed
+// against to build an agent's SPIFFE path.
+type agentPathTemplateData struct {
+	AccountId             string
+	InstanceId            string
+	Region                string
+	AvailabilityZone      string
+	ImageId               string
+	InstanceType          string
+	VpcId                 string
+	SubnetId              string
+	IAMInstanceProfileARN string
+	Tags                  map[string]string
+}
 
-	awsCaCertPublicKey *rsa.PublicKey
+// pathTraversalReplacer neutralizes the characters an agent_path_template
+// field value needs to escape its own path segment: "/" would introduce an
+// extra segment, and ".." would walk back up through parent segments once
+// the rendered template is path.Cleaned. Tag keys and values in particular
+// are set via ec2:CreateTags by anyone with access to the instance (now
+// potentially a different AWS account, via AccountAssumeRoleARNs), so they
+// must not be trusted to stay within their own path segment.
+var pathTraversalReplacer = strings.NewReplacer("/", "_", "..", "__")
+
+func buildAgentPathTemplateData(doc *aia.InstanceIdentityDocument, instance *ec2.Instance) *agentPathTemplateData {
+	tags := make(map[string]string, len(instance.Tags))
+	for _, tag := range instance.Tags {
+		tags[pathTraversalReplacer.Replace(*tag.Key)] = pathTraversalReplacer.Replace(*tag.Value)
+	}
+
+	data := &agentPathTemplateData{
+		AccountId:        pathTraversalReplacer.Replace(doc.AccountId),
+		InstanceId:       pathTraversalReplacer.Replace(doc.InstanceId),
+		Region:           pathTraversalReplacer.Replace(doc.Region),
+		AvailabilityZone: pathTraversalReplacer.Replace(doc.AvailabilityZone),
+		ImageId:          pathTraversalReplacer.Replace(doc.ImageId),
+		InstanceType:     pathTraversalReplacer.Replace(doc.InstanceType),
+		Tags:             tags,
+	}
 
-	mtx *sync.Mutex
+	if instance.VpcId != nil {
+		data.VpcId = pathTraversalReplacer.Replace(*instance.VpcId)
+	}
+	if instance.SubnetId != nil {
+		data.SubnetId = pathTraversalReplacer.Replace(*instance.SubnetId)
+	}
+	if instance.IamInstanceProfile != nil && instance.IamInstanceProfile.Arn != nil {
+		data.IAMInstanceProfileARN = pathTraversalReplacer.Replace(*instance.IamInstanceProfile.Arn)
+	}
+
+	return data
 }
 
-func (p *IIDAttestorPlugin) spiffeID(awsAccountId, awsInstanceId string) *url.URL {
-	spiffePath := path.Join("spire", "agent", pluginName, awsAccountId, awsInstanceId)
+// buildSelectors derives node selectors from instance that mirror its
+// AWS-native identity attributes, so SPIRE registration entries can match
+// on them directly instead of only the account/instance SPIFFE path. Which
+// kinds are built is controlled by kinds (all kinds if empty).
+func buildSelectors(kinds []string, instance *ec2.Instance) []*spirecommon.Selector {
+	if len(kinds) == 0 {
+		kinds = allSelectorKinds
+	}
+	enabled := make(map[string]bool, len(kinds))
+	for _, kind := range kinds {
+		enabled[kind] = true
+	}
+
+	var selectors []*spirecommon.Selector
+	add := func(value string) {
+		selectors = append(selectors, &spirecommon.Selector{Type: pluginName, Value: value})
+	}
+
+	if enabled["tag"] {
+		for _, tag := range instance.Tags {
+			add(fmt.Sprintf("tag:%s:%s", *tag.Key, *tag.Value))
+		}
+	}
+	if enabled["sg"] {
+		for _, sg := range instance.SecurityGroups {
+			if sg.GroupId != nil {
+				add(fmt.Sprintf("sg:id:%s", *sg.GroupId))
+			}
+			if sg.GroupName != nil {
+				add(fmt.Sprintf("sg:name:%s", *sg.GroupName))
+			}
+		}
+	}
+	if enabled["iamrole"] && instance.IamInstanceProfile != nil && instance.IamInstanceProfile.Arn != nil {
+		add(fmt.Sprintf("iamrole:%s", *instance.IamInstanceProfile.Arn))
+	}
+	if enabled["az"] && instance.Placement != nil && instance.Placement.AvailabilityZone != nil {
+		add(fmt.Sprintf("az:%s", *instance.Placement.AvailabilityZone))
+	}
+	if enabled["vpc"] && instance.VpcId != nil {
+		add(fmt.Sprintf("vpc:id:%s", *instance.VpcId))
+	}
+	if enabled["subnet"] && instance.SubnetId != nil {
+		add(fmt.Sprintf("subnet:id:%s", *instance.SubnetId))
+	}
+	if enabled["image"] && instance.ImageId != nil {
+		add(fmt.Sprintf("image:id:%s", *instance.ImageId))
+	}
+	if enabled["instance"] && instance.InstanceType != nil {
+		add(fmt.Sprintf("instance:type:%s", *instance.InstanceType))
+	}
+
+	return selectors
+}
+
+func spiffeID(config *pluginConfig, doc *aia.InstanceIdentityDocument, instance *ec2.Instance) (*url.URL, error) {
+	var buf bytes.Buffer
+	if err := config.agentPathTemplate.Execute(&buf, buildAgentPathTemplateData(doc, instance)); err != nil {
+		return nil, fmt.Errorf("evaluating the agent_path_template: %v", err)
+	}
+
 	id := &url.URL{
 		Scheme: "spiffe",
-		Host:   p.trustDomain,
-		Path:   spiffePath,
+		Host:   config.trustDomain,
+		Path:   path.Clean(neutralizeTraversalSegments(buf.String())),
 	}
-	return id
+	return id, nil
+}
+
+// neutralizeTraversalSegments guards the fully-rendered agent_path_template
+// output against a ".." path segment that pathTraversalReplacer's per-field
+// sanitization can't catch: two adjacent fields each containing a single "."
+// (harmless in isolation) concatenate into a literal ".." if the template
+// places them with no separator between them. Applied just before
+// path.Clean, which is what would actually act on the ".." and walk back up
+// through a preceding segment.
+func neutralizeTraversalSegments(rendered string) string {
+	segments := strings.Split(rendered, "/")
+	for i, segment := range segments {
+		if segment == ".." {
+			segments[i] = pathTraversalReplacer.Replace(segment)
+		}
+	}
+	return strings.Join(segments, "/")
 }
 
 func (p *IIDAttestorPlugin) Attest(req *nodeattestor.AttestRequest) (*nodeattestor.AttestResponse, error) {
@@ -93,37 +302,62 @@ func (p *IIDAttestorPlugin) Attest(req *nodeattestor.AttestRequest) (*nodeattest
 		return &nodeattestor.AttestResponse{Valid: false}, err
 	}
 
-	if req.AttestedBefore {
-		err = attestationStepError("validating the IID", "the IID has been used and is no longer valid")
+	pendingTime, err := time.Parse(time.RFC3339, doc.PendingTime)
+	if err != nil {
+		err = attestationStepError("parsing the IID's pendingTime", err)
 		return &nodeattestor.AttestResponse{Valid: false}, err
 	}
 
-	docHash := sha256.Sum256([]byte(attestedData.Document))
+	// config is loaded once as a snapshot: Attest never holds a lock across
+	// the DescribeInstances/AssumeRole/nonceStore round trips below, so a
+	// slow or throttled call for one agent doesn't head-of-line-block every
+	// other agent attesting concurrently.
+	config, err := p.loadConfig()
 	if err != nil {
-		err = attestationStepError("hashing the IID", err)
 		return &nodeattestor.AttestResponse{Valid: false}, err
 	}
 
-	sigBytes, err := base64.StdEncoding.DecodeString(attestedData.Signature)
+	// Apart from doc.Region, which only selects the partition whose trust
+	// anchors the signature is checked against, nothing below this point may
+	// key off doc's fields until the signature verifies: until then, doc is
+	// attacker-controlled JSON that happens to parse, not an AWS-issued
+	// identity document. Checking age or account
+	// whitelist membership first would let an unauthenticated caller probe
+	// both via the distinct error returned, without ever presenting a
+	// valid AWS signature.
+	err = verifyDocumentSignature(config, []byte(attestedData.Document), &attestedData, doc.Region)
 	if err != nil {
-		err = attestationStepError("base64 decoding the IID signature", err)
+		err = attestationStepError("verifying the cryptographic signature", err)
 		return &nodeattestor.AttestResponse{Valid: false}, err
 	}
 
-	p.mtx.Lock()
-	defer p.mtx.Unlock()
+	if age := time.Since(pendingTime); age > config.iidTTL {
+		innerErr := fmt.Errorf("document is %s old, which exceeds the configured iid_ttl of %s", age, config.iidTTL)
+		err = attestationStepError("validating the IID's age", innerErr)
+		return &nodeattestor.AttestResponse{Valid: false}, err
+	}
 
-	err = rsa.VerifyPKCS1v15(p.awsCaCertPublicKey, crypto.SHA256, docHash[:], sigBytes)
-	if err != nil {
-		err = attestationStepError("verifying the cryptographic signature", err)
+	if len(config.accountWhitelist) > 0 && !config.accountWhitelist[doc.AccountId] {
+		innerErr := fmt.Errorf("account %q is not in the account_whitelist", doc.AccountId)
+		err = attestationStepError("validating the AWS account", innerErr)
+		return &nodeattestor.AttestResponse{Valid: false}, err
+	}
+
+	docHashBytes := sha256.Sum256([]byte(attestedData.Document))
+	docHash := hex.EncodeToString(docHashBytes[:])
+	if err := config.nonceStore.Check(docHash); err != nil {
+		err = attestationStepError("validating the IID", err)
 		return &nodeattestor.AttestResponse{Valid: false}, err
 	}
 
 	awsSession := session.Must(session.NewSession())
 
-	ec2Client := ec2.New(awsSession, &aws.Config{
-		Region: &doc.Region,
-	})
+	awsConfig := &aws.Config{Region: &doc.Region}
+	if roleARN, ok := config.accountAssumeRoleARNs[doc.AccountId]; ok && roleARN != "" {
+		awsConfig.Credentials = stscreds.NewCredentials(awsSession, roleARN)
+	}
+
+	ec2Client := ec2.New(awsSession, awsConfig)
 
 	query := &ec2.DescribeInstancesInput{
 		InstanceIds: []*string{&doc.InstanceId},
@@ -171,53 +405,200 @@ func (p *IIDAttestorPlugin) Attest(req *nodeattestor.AttestRequest) (*nodeattest
 		return &nodeattestor.AttestResponse{Valid: false}, err
 	}
 
+	spiffeID, err := spiffeID(config, &doc, instance)
+	if err != nil {
+		err = attestationStepError("building the agent SPIFFE ID", err)
+		return &nodeattestor.AttestResponse{Valid: false}, err
+	}
+
+	// Only now that every check has passed do we burn the nonce: recording
+	// it any earlier would permanently lock a legitimate agent out for the
+	// rest of iid_ttl if a later check failed transiently (a throttled AWS
+	// API call, a momentary device-index mismatch).
+	if err := config.nonceStore.Record(docHash); err != nil {
+		err = attestationStepError("validating the IID", err)
+		return &nodeattestor.AttestResponse{Valid: false}, err
+	}
+
 	resp := &nodeattestor.AttestResponse{
 		Valid:        true,
-		BaseSPIFFEID: p.spiffeID(doc.AccountId, doc.InstanceId).String(),
+		BaseSPIFFEID: spiffeID.String(),
+		Selectors:    buildSelectors(config.selectorKinds, instance),
 	}
 
 	return resp, nil
 }
 
+// verifyDocumentSignature verifies document against attestedData.Signature,
+// using the trust anchors for the AWS partition that region belongs to, and
+// choosing the verification path based on config.signatureAlgorithm: the
+// legacy SHA1+PKCS1v15 signature, or the newer SHA256/PKCS7 signature.
+func verifyDocumentSignature(config *pluginConfig, document []byte, attestedData *aia.IidAttestedData, region string) error {
+	partition := aia.PartitionForRegion(region)
+	anchors := config.trustBundle[partition]
+	if len(anchors) == 0 {
+		return fmt.Errorf("no trust anchors configured for AWS partition %q (region %q)", partition, region)
+	}
+
+	switch config.signatureAlgorithm {
+	case aia.SignatureAlgorithmPKCS7:
+		return verifyPKCS7Signature(document, attestedData.Signature, anchors)
+	default:
+		return verifySHA1Signature(document, attestedData.Signature, anchors)
+	}
+}
+
+func verifySHA1Signature(document []byte, signatureBase64 string, anchors []*x509.Certificate) error {
+	sigBytes, err := base64.StdEncoding.DecodeString(signatureBase64)
+	if err != nil {
+		return fmt.Errorf("base64 decoding the IID signature: %v", err)
+	}
+
+	docHash := sha1.Sum(document)
+
+	var tried []string
+	for _, cert := range anchors {
+		key, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		if rsa.VerifyPKCS1v15(key, crypto.SHA1, docHash[:], sigBytes) == nil {
+			return nil
+		}
+		tried = append(tried, cert.Subject.String())
+	}
+	return fmt.Errorf("signature did not verify against any trust anchor (tried: %s)", strings.Join(tried, ", "))
+}
+
+func verifyPKCS7Signature(document []byte, signatureBase64 string, anchors []*x509.Certificate) error {
+	der, err := base64.StdEncoding.DecodeString(signatureBase64)
+	if err != nil {
+		return fmt.Errorf("base64 decoding the PKCS7 signature: %v", err)
+	}
+
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		return fmt.Errorf("parsing the PKCS7 signature: %v", err)
+	}
+
+	p7.Content = document
+	if err := p7.Verify(); err != nil {
+		return fmt.Errorf("verifying the PKCS7 signature: %v", err)
+	}
+
+	// p7.Certificates is an unauthenticated bag the signer populates and may
+	// contain certificates p7.Verify never actually checked a signature
+	// against -- a forged envelope could pair its own throwaway signer with
+	// a decoy AWS certificate stuffed into this slice. GetOnlySigner looks
+	// up the one certificate actually bound to the (sole) verified
+	// SignerInfo by its IssuerAndSerialNumber, the same lookup p7.Verify
+	// used internally, so only that certificate may be checked against the
+	// trusted anchors.
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		return fmt.Errorf("PKCS7 signature does not have exactly one verified signer")
+	}
+
+	for _, anchor := range anchors {
+		if signer.CheckSignatureFrom(anchor) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("PKCS7 signer %q does not chain to any trusted AWS root", signer.Subject.String())
+}
+
 func attestationStepError(step string, cause error) error {
-	return fmt.Errorf("Attempted AWS IID attestation but an error occured %s: %s", step, err)
+	return fmt.Errorf("Attempted AWS IID attestation but an error occured %s: %s", step, cause)
 }
 
 func (p *IIDAttestorPlugin) Configure(req *spi.ConfigureRequest) (*spi.ConfigureResponse, error) {
 	resp := &spi.ConfigureResponse{}
 
 	// Parse HCL config payload into config struct
-	config := &IIDAttestorConfig{}
+	hclConfig := &IIDAttestorConfig{}
 	hclTree, err := hcl.Parse(req.Configuration)
 	if err != nil {
 		err := fmt.Errorf("Error parsing AWS IID Attestor configuration: %s", err)
 		return resp, err
 	}
-	err = hcl.DecodeObject(&config, hclTree)
+	err = hcl.DecodeObject(&hclConfig, hclTree)
 	if err != nil {
 		err := fmt.Errorf("Error decoding AWS IID Attestor configuration: %v", err)
 		return resp, err
 	}
 
-	block, _ := pem.Decode([]byte(awsCaCertPEM))
-
-	awsCaCert, err := x509.ParseCertificate(block.Bytes)
+	trustBundle, err := aia.DefaultTrustBundle()
 	if err != nil {
-		err := fmt.Errorf("Error reading the AWS CA Certificate in the AWS IID Attestor: %v", err)
+		err := fmt.Errorf("Error building the AWS IID Attestor trust bundle: %v", err)
 		return resp, err
 	}
 
-	awsCaCertPublicKey, ok := awsCaCert.PublicKey.(*rsa.PublicKey)
-	if !ok {
-		err := fmt.Errorf("Error extracting the AWS CA Certificate's public key in the AWS IID Attestor: %v", err)
+	for partition, path := range hclConfig.CABundlePaths {
+		if err := aia.LoadCABundleFile(trustBundle, partition, path); err != nil {
+			err := fmt.Errorf("Error loading the AWS IID Attestor ca_bundle_paths: %v", err)
+			return resp, err
+		}
+	}
+
+	signatureAlgorithm := hclConfig.SignatureAlgorithm
+	if signatureAlgorithm == "" {
+		if hclConfig.ImdsVersion == "2" {
+			signatureAlgorithm = aia.SignatureAlgorithmPKCS7
+		} else {
+			signatureAlgorithm = aia.SignatureAlgorithmSHA1
+		}
+	}
+
+	agentPathTemplateText := hclConfig.AgentPathTemplate
+	if agentPathTemplateText == "" {
+		agentPathTemplateText = defaultAgentPathTemplate
+	}
+	agentPathTemplate, err := template.New("agent_path_template").Parse(agentPathTemplateText)
+	if err != nil {
+		err := fmt.Errorf("Error parsing the AWS IID Attestor agent_path_template: %v", err)
 		return resp, err
 	}
 
-	p.mtx.Lock()
-	defer p.mtx.Unlock()
+	accountWhitelist := make(map[string]bool, len(hclConfig.AccountWhitelist))
+	for _, accountId := range hclConfig.AccountWhitelist {
+		accountWhitelist[accountId] = true
+	}
+
+	iidTTL := defaultIidTTL
+	if hclConfig.IidTTL != "" {
+		iidTTL, err = time.ParseDuration(hclConfig.IidTTL)
+		if err != nil {
+			err := fmt.Errorf("Error parsing the AWS IID Attestor iid_ttl: %v", err)
+			return resp, err
+		}
+	}
+
+	var nonceStore aia.NonceStore
+	if hclConfig.NonceStoreDynamoDBTable != "" {
+		dynamoClient := dynamodb.New(session.Must(session.NewSession()))
+		nonceStore = aia.NewDynamoDBNonceStore(dynamoClient, hclConfig.NonceStoreDynamoDBTable, iidTTL)
+	} else {
+		nonceStore = aia.NewLRUNonceStore(iidTTL, defaultNonceStoreMaxEntries)
+	}
+
+	newConfig := &pluginConfig{
+		trustDomain:           hclConfig.TrustDomain,
+		signatureAlgorithm:    signatureAlgorithm,
+		trustBundle:           trustBundle,
+		accountWhitelist:      accountWhitelist,
+		accountAssumeRoleARNs: hclConfig.AccountAssumeRoleARNs,
+		agentPathTemplate:     agentPathTemplate,
+		iidTTL:                iidTTL,
+		nonceStore:            nonceStore,
+		selectorKinds:         hclConfig.Selectors,
+	}
 
-	p.trustDomain = config.TrustDomain
-	p.awsCaCertPublicKey = awsCaCertPublicKey
+	// configureMtx only serializes this Configure call against any other
+	// one racing it; Attest reads p.config without ever taking it.
+	p.configureMtx.Lock()
+	defer p.configureMtx.Unlock()
+
+	p.config.Store(newConfig)
 
 	return &spi.ConfigureResponse{}, nil
 }
@@ -227,15 +608,11 @@ func (*IIDAttestorPlugin) GetPluginInfo(*spi.GetPluginInfoRequest) (*spi.GetPlug
 }
 
 func New() nodeattestor.NodeAttestor {
-	return &IIDAttestorPlugin{
-		mtx: &sync.Mutex{},
-	}
+	return &IIDAttestorPlugin{}
 }
 
 func main() {
-	p := &IIDAttestorPlugin{
-		mtx: &sync.Mutex{},
-	}
+	p := &IIDAttestorPlugin{}
 
 	plugin.Serve(&plugin.ServeConfig{
 		HandshakeConfig: nodeattestor.Handshake,
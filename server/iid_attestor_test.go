@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	ec2 "github.com/aws/aws-sdk-go/service/ec2"
+
+	aia "github.com/spiffe/aws-iid-attestor/common"
+)
+
+func TestBuildAgentPathTemplateDataNeutralizesPathTraversal(t *testing.T) {
+	doc := &aia.InstanceIdentityDocument{
+		AccountId: "../../foo",
+	}
+	instance := &ec2.Instance{
+		Tags: []*ec2.Tag{
+			{Key: aws.String("name"), Value: aws.String("../../foo")},
+			{Key: aws.String("team"), Value: aws.String("a/b")},
+		},
+	}
+
+	data := buildAgentPathTemplateData(doc, instance)
+
+	for _, got := range []string{data.AccountId, data.Tags["name"], data.Tags["team"]} {
+		if strings.Contains(got, "/") || strings.Contains(got, "..") {
+			t.Errorf("value %q still contains an unescaped path-traversal character", got)
+		}
+	}
+}
+
+func TestSpiffeIDNeutralizesCrossFieldTraversal(t *testing.T) {
+	// Two adjacent template fields each holding a single "." are harmless
+	// individually -- pathTraversalReplacer leaves "." untouched -- but a
+	// template with no separator between them concatenates those into a
+	// literal ".." path segment, which path.Clean would then resolve as
+	// walking back up through the preceding AccountId segment.
+	tmpl, err := template.New("agent_path_template").Parse("spire/agent/{{ .AccountId }}/{{ .Tags.a }}{{ .Tags.b }}/{{ .InstanceId }}")
+	if err != nil {
+		t.Fatalf("parsing template: %v", err)
+	}
+	config := &pluginConfig{
+		trustDomain:       "example.org",
+		agentPathTemplate: tmpl,
+	}
+	doc := &aia.InstanceIdentityDocument{
+		AccountId:  "123456789012",
+		InstanceId: "i-abcdef",
+	}
+	instance := &ec2.Instance{
+		Tags: []*ec2.Tag{
+			{Key: aws.String("a"), Value: aws.String(".")},
+			{Key: aws.String("b"), Value: aws.String(".")},
+		},
+	}
+
+	id, err := spiffeID(config, doc, instance)
+	if err != nil {
+		t.Fatalf("spiffeID: %v", err)
+	}
+
+	if !strings.HasPrefix(id.Path, "/spire/agent/123456789012/") {
+		t.Errorf("Path = %q, walked back up through the AccountId segment", id.Path)
+	}
+}
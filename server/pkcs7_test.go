@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/fullsailor/pkcs7"
+)
+
+// testCA generates a self-signed CA certificate and key, standing in for an
+// AWS signing root in these tests.
+func testCA(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing CA certificate: %v", err)
+	}
+	return cert, key
+}
+
+// testLeaf generates a certificate and key signed by ca/caKey.
+func testLeaf(t *testing.T, serial int64, ca *x509.Certificate, caKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating leaf key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "test leaf"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating leaf certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing leaf certificate: %v", err)
+	}
+	return cert, key
+}
+
+// signPKCS7 builds a detached PKCS7 SignedData over document, signed by
+// leaf/leafKey with leaf's issuing chain attached.
+func signPKCS7(t *testing.T, document []byte, leaf *x509.Certificate, leafKey *rsa.PrivateKey, chain []*x509.Certificate) string {
+	sd, err := pkcs7.NewSignedData(document)
+	if err != nil {
+		t.Fatalf("pkcs7.NewSignedData: %v", err)
+	}
+	if err := sd.AddSignerChain(leaf, leafKey, chain, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatalf("AddSignerChain: %v", err)
+	}
+	sd.Detach()
+	der, err := sd.Finish()
+	if err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+func TestVerifyPKCS7SignatureAcceptsChainToAnchor(t *testing.T) {
+	ca, caKey := testCA(t)
+	leaf, leafKey := testLeaf(t, 2, ca, caKey)
+
+	document := []byte(`{"accountId":"123456789012"}`)
+	sig := signPKCS7(t, document, leaf, leafKey, []*x509.Certificate{ca})
+
+	if err := verifyPKCS7Signature(document, sig, []*x509.Certificate{ca}); err != nil {
+		t.Errorf("verifyPKCS7Signature rejected a signer that chains to the trusted anchor: %v", err)
+	}
+}
+
+func TestVerifyPKCS7SignatureRejectsUntrustedSigner(t *testing.T) {
+	// The attacker signs with their own throwaway CA/leaf, entirely
+	// unrelated to the anchor the server actually trusts.
+	attackerCA, attackerCAKey := testCA(t)
+	attackerLeaf, attackerLeafKey := testLeaf(t, 2, attackerCA, attackerCAKey)
+
+	anchor, _ := testCA(t)
+
+	document := []byte(`{"accountId":"123456789012"}`)
+	sig := signPKCS7(t, document, attackerLeaf, attackerLeafKey, []*x509.Certificate{attackerCA})
+
+	if err := verifyPKCS7Signature(document, sig, []*x509.Certificate{anchor}); err == nil {
+		t.Error("verifyPKCS7Signature accepted a signer that does not chain to any trusted anchor")
+	}
+}